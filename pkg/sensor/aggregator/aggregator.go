@@ -0,0 +1,337 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aggregator maintains rolling, privacy-preserving aggregates
+// of the sensor's telemetry event stream and periodically ships them
+// to a configurable collector endpoint. It is an opt-in, fleet-wide
+// health view: unlike the per-event gRPC subscription path or
+// TelemetryOTLPSink, it never transmits a raw PID, path, or container
+// ID, only sketches built from them.
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Config configures an Aggregator.
+type Config struct {
+	// Endpoint is the collector URL aggregates are POSTed to, e.g.
+	// "https://collector.example.com/v1/aggregates". Set via the
+	// --telemetry.aggregator.endpoint flag.
+	Endpoint string
+	// TickInterval is how often the aggregator snapshots, resets, and
+	// ships its sketches. Defaults to 60s.
+	TickInterval time.Duration
+	// QueueDir is where snapshots that failed to POST are persisted
+	// for later retry. Defaults to "aggregator-queue" under the
+	// current working directory.
+	QueueDir string
+	// TopK bounds how many syscalls/container images are tracked by
+	// the top-K tables, each with its count-min estimate. Defaults to
+	// 32.
+	TopK int
+	// HTTPClient is used to POST snapshots; defaults to
+	// http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+func (c Config) withDefaults() Config {
+	if c.TickInterval <= 0 {
+		c.TickInterval = 60 * time.Second
+	}
+	if c.QueueDir == "" {
+		c.QueueDir = "aggregator-queue"
+	}
+	if c.TopK <= 0 {
+		c.TopK = 32
+	}
+	if c.HTTPClient == nil {
+		c.HTTPClient = http.DefaultClient
+	}
+	return c
+}
+
+// Aggregator maintains the rolling sketches described in package
+// aggregator's doc comment and ships them to Config.Endpoint on every
+// tick.
+type Aggregator struct {
+	sensorID string
+	config   Config
+	queue    *diskQueue
+
+	mu                   sync.Mutex
+	uniqueProcessIDs     *hyperLogLog
+	uniqueContainerIDs   *hyperLogLog
+	uniqueExecutables    *hyperLogLog
+	topSyscalls          *topK
+	topContainerImages   *topK
+	histogramsByCPU      map[uint32]*decayingHistogram
+	lastEventAtByCPU     map[uint32]float64
+	eventKindCounts      map[string]uint64
+	credTransitionCounts map[string]uint64
+
+	latestMu sync.RWMutex
+	latest   *Snapshot
+
+	stopOnce sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// New builds an Aggregator for sensorID. Call Run to start its
+// background tick loop.
+func New(sensorID string, config Config) (*Aggregator, error) {
+	config = config.withDefaults()
+
+	queue, err := newDiskQueue(config.QueueDir)
+	if err != nil {
+		return nil, fmt.Errorf("initializing aggregator disk queue: %w", err)
+	}
+
+	a := &Aggregator{
+		sensorID:             sensorID,
+		config:               config,
+		queue:                queue,
+		uniqueProcessIDs:     newHyperLogLog(),
+		uniqueContainerIDs:   newHyperLogLog(),
+		uniqueExecutables:    newHyperLogLog(),
+		topSyscalls:          newTopK(config.TopK),
+		topContainerImages:   newTopK(config.TopK),
+		histogramsByCPU:      make(map[uint32]*decayingHistogram),
+		lastEventAtByCPU:     make(map[uint32]float64),
+		eventKindCounts:      make(map[string]uint64),
+		credTransitionCounts: make(map[string]uint64),
+		stop:                 make(chan struct{}),
+		done:                 make(chan struct{}),
+	}
+	return a, nil
+}
+
+// Observation is the subset of a TelemetryEventData that the
+// aggregator needs. It is defined independently of
+// sensor.TelemetryEventData so that this package does not import
+// pkg/sensor (which would create an import cycle, since the sensor
+// package is what drives the aggregator).
+type Observation struct {
+	ProcessID            string
+	ContainerID          string
+	ContainerImage       string
+	ExecutablePath       string
+	Syscall              string
+	CPU                  uint32
+	MonotimeNanos        int64
+	EventKind            string
+	CredentialTransition string // empty if this event is not a credential change
+}
+
+// Observe folds one telemetry event into the current aggregation
+// window. It is safe for concurrent use.
+func (a *Aggregator) Observe(o Observation) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if o.ProcessID != "" {
+		a.uniqueProcessIDs.add(o.ProcessID)
+	}
+	if o.ContainerID != "" {
+		a.uniqueContainerIDs.add(o.ContainerID)
+	}
+	if o.ExecutablePath != "" {
+		a.uniqueExecutables.add(o.ExecutablePath)
+	}
+	if o.Syscall != "" {
+		a.topSyscalls.add(o.Syscall)
+	}
+	if o.ContainerImage != "" {
+		a.topContainerImages.add(o.ContainerImage)
+	}
+
+	now := float64(o.MonotimeNanos) / 1e9
+	hist, ok := a.histogramsByCPU[o.CPU]
+	if !ok {
+		hist = newDecayingHistogram()
+		a.histogramsByCPU[o.CPU] = hist
+	}
+	if last, ok := a.lastEventAtByCPU[o.CPU]; ok {
+		interArrivalNanos := float64(o.MonotimeNanos) - last*1e9
+		if interArrivalNanos >= 0 {
+			hist.observe(now, interArrivalNanos)
+		}
+	}
+	a.lastEventAtByCPU[o.CPU] = now
+
+	if o.EventKind != "" {
+		a.eventKindCounts[o.EventKind]++
+	}
+	if o.CredentialTransition != "" {
+		a.credTransitionCounts[o.CredentialTransition]++
+	}
+}
+
+// Run starts the aggregator's tick loop. It blocks until ctx is
+// canceled or Stop is called.
+func (a *Aggregator) Run(ctx context.Context) {
+	defer close(a.done)
+
+	// Replay anything left over from a prior run before the first
+	// tick, in case the collector has since come back up.
+	a.flushQueue()
+
+	ticker := time.NewTicker(a.config.TickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.tick()
+		}
+	}
+}
+
+// Stop signals the tick loop to exit and waits for it to do so. It is
+// safe to call more than once.
+func (a *Aggregator) Stop() {
+	a.stopOnce.Do(func() {
+		close(a.stop)
+	})
+	<-a.done
+}
+
+func (a *Aggregator) tick() {
+	snapshot := a.snapshotAndReset()
+
+	a.latestMu.Lock()
+	a.latest = snapshot
+	a.latestMu.Unlock()
+
+	payload := snapshot.Marshal()
+	if err := a.send(payload); err != nil {
+		if qerr := a.queue.enqueue(payload); qerr != nil {
+			// Both the network send and the durable fallback
+			// failed; there is nowhere else to put this
+			// snapshot, so it is dropped.
+			return
+		}
+	}
+	a.flushQueue()
+}
+
+func (a *Aggregator) flushQueue() {
+	a.queue.drain(a.send)
+}
+
+func (a *Aggregator) send(payload []byte) error {
+	if a.config.Endpoint == "" {
+		return fmt.Errorf("aggregator: no endpoint configured")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, a.config.Endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+
+	resp, err := a.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("aggregator: collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// snapshotAndReset atomically captures the current sketches into a
+// Snapshot and resets them for the next window.
+func (a *Aggregator) snapshotAndReset() *Snapshot {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	snapshot := &Snapshot{
+		SensorID:                   a.sensorID,
+		KernelVersion:              kernelRelease(),
+		Uname:                      fmt.Sprintf("%s/%s", runtime.GOOS, runtime.GOARCH),
+		UniqueProcessIDs:           a.uniqueProcessIDs.snapshot(),
+		UniqueContainerIDs:         a.uniqueContainerIDs.snapshot(),
+		UniqueExecutablePaths:      a.uniqueExecutables.snapshot(),
+		TopSyscalls:                TopKSnapshot{Entries: a.topSyscalls.entries()},
+		TopContainerImages:         TopKSnapshot{Entries: a.topContainerImages.entries()},
+		EventKindCounts:            a.eventKindCounts,
+		CredentialTransitionCounts: a.credTransitionCounts,
+	}
+
+	for cpu, hist := range a.histogramsByCPU {
+		// Decay on the same clock Observe feeds the histogram with
+		// (event monotime), up to this CPU's latest event.
+		bounds, weights := hist.snapshot(a.lastEventAtByCPU[cpu])
+		snapshot.Histograms = append(snapshot.Histograms, HistogramSnapshot{
+			CPU:     cpu,
+			Bounds:  bounds,
+			Weights: weights,
+		})
+	}
+
+	a.uniqueProcessIDs.reset()
+	a.uniqueContainerIDs.reset()
+	a.uniqueExecutables.reset()
+	a.topSyscalls.reset()
+	a.topContainerImages.reset()
+	for _, hist := range a.histogramsByCPU {
+		hist.reset()
+	}
+	a.eventKindCounts = make(map[string]uint64)
+	a.credTransitionCounts = make(map[string]uint64)
+
+	return snapshot
+}
+
+// ServeHTTP exposes the most recently taken snapshot for local
+// scraping, so an operator (or a sidecar) can inspect the aggregator's
+// latest window without waiting on the collector round trip. The
+// snapshot is served whether or not the collector accepted it.
+func (a *Aggregator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.latestMu.RLock()
+	snapshot := a.latest
+	a.latestMu.RUnlock()
+
+	if snapshot == nil {
+		http.Error(w, "no snapshot taken yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(snapshot.Marshal())
+}
+
+func kernelRelease() string {
+	var uname unix.Utsname
+	if err := unix.Uname(&uname); err != nil {
+		return "unknown"
+	}
+	return unix.ByteSliceToString(uname.Release[:])
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func newTestAggregator(t *testing.T, config Config) *Aggregator {
+	t.Helper()
+	if config.QueueDir == "" {
+		config.QueueDir = t.TempDir()
+	}
+	a, err := New("test-sensor", config)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return a
+}
+
+func TestAggregatorObserveAndSnapshotAndReset(t *testing.T) {
+	a := newTestAggregator(t, Config{})
+
+	a.Observe(Observation{
+		ProcessID:      "pid-1",
+		ContainerID:    "container-1",
+		ExecutablePath: "/usr/bin/curl",
+		Syscall:        "execve",
+		ContainerImage: "alpine:latest",
+		CPU:            0,
+		MonotimeNanos:  1_000_000_000,
+		EventKind:      "process.exec",
+	})
+	a.Observe(Observation{
+		EventKind:            "process.cred_change",
+		CredentialTransition: "uid:1000->0",
+		CPU:                  0,
+		MonotimeNanos:        2_000_000_000,
+	})
+
+	snapshot := a.snapshotAndReset()
+
+	if got := snapshot.EventKindCounts["process.exec"]; got != 1 {
+		t.Errorf("EventKindCounts[process.exec] = %d, want 1", got)
+	}
+	if got := snapshot.CredentialTransitionCounts["uid:1000->0"]; got != 1 {
+		t.Errorf("CredentialTransitionCounts[uid:1000->0] = %d, want 1", got)
+	}
+	if len(snapshot.TopSyscalls.Entries) != 1 || snapshot.TopSyscalls.Entries[0].item != "execve" {
+		t.Errorf("TopSyscalls.Entries = %+v, want [{execve 1}]", snapshot.TopSyscalls.Entries)
+	}
+
+	// Taken immediately after, with no intervening Observe calls, the
+	// next snapshot must come back empty: snapshotAndReset resets the
+	// sketches it just read.
+	empty := a.snapshotAndReset()
+	if len(empty.EventKindCounts) != 0 {
+		t.Errorf("EventKindCounts after reset = %+v, want empty", empty.EventKindCounts)
+	}
+	if len(empty.TopSyscalls.Entries) != 0 {
+		t.Errorf("TopSyscalls.Entries after reset = %+v, want empty", empty.TopSyscalls.Entries)
+	}
+}
+
+func TestAggregatorTickQueuesOnSendFailureThenDrainsOnRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		up       bool
+		bodies   [][]byte
+		requests int32
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		serverUp := up
+		mu.Unlock()
+		if !serverUp {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		mu.Unlock()
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	a := newTestAggregator(t, Config{Endpoint: srv.URL})
+	a.Observe(Observation{EventKind: "process.exec", CPU: 0, MonotimeNanos: 1})
+
+	// The collector is down, so tick's POST fails and the snapshot
+	// must fall back to the on-disk queue rather than being dropped.
+	a.tick()
+
+	entries, err := os.ReadDir(a.config.QueueDir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s): %v", a.config.QueueDir, err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("queue dir has %d entries after a failed send, want 1", len(entries))
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+
+	a.flushQueue()
+
+	entries, err = os.ReadDir(a.config.QueueDir)
+	if err != nil {
+		t.Fatalf("ReadDir(%s): %v", a.config.QueueDir, err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("queue dir has %d entries after a successful flush, want 0", len(entries))
+	}
+	if got := atomic.LoadInt32(&requests); got != 1 {
+		t.Fatalf("collector received %d requests, want 1", got)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 || len(bodies[0]) == 0 {
+		t.Fatalf("collector bodies = %+v, want one non-empty marshaled snapshot", bodies)
+	}
+}
+
+func TestAggregatorStopIsIdempotent(t *testing.T) {
+	a := newTestAggregator(t, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go a.Run(ctx)
+
+	a.Stop()
+	a.Stop()
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"container/heap"
+	"sort"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// cmsDepth and cmsWidth fix the count-min sketch's error bounds:
+// depth independent hash rows give the confidence, width per row
+// gives the accuracy. These values bound the estimation error to
+// about e/cmsWidth (~0.13%) of total volume with probability at least
+// 1-e^-cmsDepth (~99.3%), which is more than enough precision for a
+// top-K ranking.
+const (
+	cmsDepth = 5
+	cmsWidth = 2048
+)
+
+// countMinSketch counts approximate frequencies of a high-cardinality
+// key space (e.g. syscall names, container image references) in
+// constant memory.
+type countMinSketch struct {
+	counts [cmsDepth][cmsWidth]uint32
+}
+
+func newCountMinSketch() *countMinSketch {
+	return &countMinSketch{}
+}
+
+func (c *countMinSketch) add(item string, count uint32) {
+	for row := 0; row < cmsDepth; row++ {
+		idx := cmsHash(item, row) % cmsWidth
+		c.counts[row][idx] += count
+	}
+}
+
+func (c *countMinSketch) estimate(item string) uint32 {
+	min := ^uint32(0)
+	for row := 0; row < cmsDepth; row++ {
+		idx := cmsHash(item, row) % cmsWidth
+		if v := c.counts[row][idx]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (c *countMinSketch) reset() {
+	*c = countMinSketch{}
+}
+
+func cmsHash(item string, row int) uint32 {
+	return murmur3.Sum32WithSeed([]byte(item), uint32(row))
+}
+
+// topKEntry is one ranked item in a topK tracker.
+type topKEntry struct {
+	item  string
+	count uint32
+}
+
+// topK tracks a bounded number of candidate items, each with its
+// count-min sketch estimate; the counts it reports are those
+// estimates, not exact counts. An item not yet tracked replaces the
+// lowest tracked item once its estimate exceeds that item's. k is
+// expected to be
+// small (tens of entries), so membership is checked with a linear
+// scan rather than an auxiliary index.
+type topK struct {
+	k      int
+	sketch *countMinSketch
+	heap   topKHeap
+}
+
+func newTopK(k int) *topK {
+	return &topK{
+		k:      k,
+		sketch: newCountMinSketch(),
+	}
+}
+
+// add records one occurrence of item.
+func (t *topK) add(item string) {
+	t.sketch.add(item, 1)
+	estimate := t.sketch.estimate(item)
+
+	for i, e := range t.heap {
+		if e.item == item {
+			t.heap[i].count = estimate
+			heap.Fix(&t.heap, i)
+			return
+		}
+	}
+
+	if len(t.heap) < t.k {
+		heap.Push(&t.heap, &topKEntry{item: item, count: estimate})
+		return
+	}
+
+	if estimate > t.heap[0].count {
+		t.heap[0] = &topKEntry{item: item, count: estimate}
+		heap.Fix(&t.heap, 0)
+	}
+}
+
+// entries returns the tracked items ranked highest-count first. The
+// backing heap only guarantees its root is the minimum, so the copy
+// must be sorted independently of heap order.
+func (t *topK) entries() []topKEntry {
+	out := make([]topKEntry, len(t.heap))
+	for i, e := range t.heap {
+		out[i] = *e
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].count > out[j].count })
+	return out
+}
+
+func (t *topK) reset() {
+	t.sketch.reset()
+	t.heap = nil
+}
+
+// topKHeap is a min-heap over *topKEntry by count, so the smallest
+// tracked count is always evictable in O(log k).
+type topKHeap []*topKEntry
+
+func (h topKHeap) Len() int            { return len(h) }
+func (h topKHeap) Less(i, j int) bool  { return h[i].count < h[j].count }
+func (h topKHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *topKHeap) Push(x interface{}) { *h = append(*h, x.(*topKEntry)) }
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+	return item
+}
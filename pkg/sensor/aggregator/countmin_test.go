@@ -0,0 +1,116 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinSketchEstimateNeverUndercounts(t *testing.T) {
+	c := newCountMinSketch()
+	c.add("a", 5)
+	c.add("b", 3)
+
+	if got := c.estimate("a"); got < 5 {
+		t.Errorf("estimate(a) = %d, want >= 5", got)
+	}
+	if got := c.estimate("b"); got < 3 {
+		t.Errorf("estimate(b) = %d, want >= 3", got)
+	}
+	if got := c.estimate("never-added"); got != 0 {
+		t.Errorf("estimate(never-added) = %d, want 0", got)
+	}
+}
+
+func TestCountMinSketchReset(t *testing.T) {
+	c := newCountMinSketch()
+	c.add("a", 100)
+	c.reset()
+
+	if got := c.estimate("a"); got != 0 {
+		t.Errorf("estimate(a) = %d after reset, want 0", got)
+	}
+}
+
+func TestTopKRanksByFrequency(t *testing.T) {
+	top := newTopK(2)
+
+	for i := 0; i < 10; i++ {
+		top.add("frequent")
+	}
+	for i := 0; i < 5; i++ {
+		top.add("medium")
+	}
+	top.add("rare")
+
+	entries := top.entries()
+	if len(entries) != 2 {
+		t.Fatalf("entries() returned %d entries, want 2 (k=2)", len(entries))
+	}
+
+	names := map[string]bool{}
+	for _, e := range entries {
+		names[e.item] = true
+	}
+	if !names["frequent"] || !names["medium"] {
+		t.Errorf("entries() = %+v, want the two most frequent items (frequent, medium)", entries)
+	}
+	if names["rare"] {
+		t.Errorf("entries() = %+v, want the least frequent item (rare) evicted", entries)
+	}
+
+	if entries[0].item != "frequent" || entries[1].item != "medium" {
+		t.Errorf("entries() = %+v, want ranked highest-count first: [frequent, medium]", entries)
+	}
+}
+
+func TestTopKEntriesSortedDescending(t *testing.T) {
+	top := newTopK(3)
+	for i := 0; i < 3; i++ {
+		top.add("third")
+	}
+	for i := 0; i < 9; i++ {
+		top.add("first")
+	}
+	for i := 0; i < 6; i++ {
+		top.add("second")
+	}
+
+	entries := top.entries()
+	if len(entries) != 3 {
+		t.Fatalf("entries() returned %d entries, want 3 (k=3)", len(entries))
+	}
+	for i := 1; i < len(entries); i++ {
+		if entries[i-1].count < entries[i].count {
+			t.Fatalf("entries() = %+v, want non-increasing counts", entries)
+		}
+	}
+	if entries[0].item != "first" || entries[1].item != "second" || entries[2].item != "third" {
+		t.Errorf("entries() = %+v, want [first, second, third]", entries)
+	}
+}
+
+func TestTopKReset(t *testing.T) {
+	top := newTopK(4)
+	for i := 0; i < 10; i++ {
+		top.add(fmt.Sprintf("item-%d", i))
+	}
+	top.reset()
+
+	if got := len(top.entries()); got != 0 {
+		t.Errorf("entries() returned %d entries after reset, want 0", got)
+	}
+}
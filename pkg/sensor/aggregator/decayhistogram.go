@@ -0,0 +1,100 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import "math"
+
+// decayHistogramBuckets are log2-scaled bucket upper bounds in
+// nanoseconds, from 1us up to ~1s. Inter-arrival time on a single CPU
+// spans that whole range between an idle sensor and a kprobe firing
+// on every syscall.
+var decayHistogramBuckets = buildDecayHistogramBuckets()
+
+func buildDecayHistogramBuckets() []float64 {
+	const (
+		first = 1000.0       // 1us
+		last  = 1000000000.0 // 1s
+	)
+	bounds := []float64{}
+	for b := first; b < last; b *= 2 {
+		bounds = append(bounds, b)
+	}
+	return append(bounds, last)
+}
+
+// decayHalfLife controls how quickly older samples lose weight
+// relative to new ones, so the histogram reflects recent behavior
+// rather than an unbounded lifetime average.
+const decayHalfLife = 60.0 // seconds
+
+// decayingHistogram is an exponentially-decaying histogram of
+// inter-arrival times between events on a single CPU. Each bucket
+// holds a decayed count rather than a raw count, so the shape of the
+// distribution always reflects recent activity.
+type decayingHistogram struct {
+	buckets   []float64
+	lastDecay float64 // seconds, monotonic clock supplied by caller
+}
+
+func newDecayingHistogram() *decayingHistogram {
+	return &decayingHistogram{
+		buckets: make([]float64, len(decayHistogramBuckets)),
+	}
+}
+
+// observe decays all buckets to nowSeconds and records one sample of
+// durationNanos.
+func (h *decayingHistogram) observe(nowSeconds float64, durationNanos float64) {
+	h.decay(nowSeconds)
+
+	idx := len(decayHistogramBuckets) - 1
+	for i, bound := range decayHistogramBuckets {
+		if durationNanos <= bound {
+			idx = i
+			break
+		}
+	}
+	h.buckets[idx]++
+}
+
+func (h *decayingHistogram) decay(nowSeconds float64) {
+	if h.lastDecay == 0 {
+		h.lastDecay = nowSeconds
+		return
+	}
+	elapsed := nowSeconds - h.lastDecay
+	if elapsed <= 0 {
+		return
+	}
+	factor := math.Exp(-elapsed * math.Ln2 / decayHalfLife)
+	for i := range h.buckets {
+		h.buckets[i] *= factor
+	}
+	h.lastDecay = nowSeconds
+}
+
+// snapshot decays to nowSeconds and returns a copy of the current
+// bucket weights, paired with their upper bounds in nanoseconds.
+func (h *decayingHistogram) snapshot(nowSeconds float64) (bounds []float64, weights []float64) {
+	h.decay(nowSeconds)
+	w := make([]float64, len(h.buckets))
+	copy(w, h.buckets)
+	return decayHistogramBuckets, w
+}
+
+func (h *decayingHistogram) reset() {
+	h.buckets = make([]float64, len(decayHistogramBuckets))
+	h.lastDecay = 0
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import "testing"
+
+// baseTime is an arbitrary non-zero point in the monotonic clock
+// newDecayingHistogram's caller supplies. 0 is reserved internally to
+// mean "never decayed yet", so tests must not use it as a real
+// timestamp.
+const baseTime = 1_000_000.0
+
+func TestDecayingHistogramObserveIncrementsBucket(t *testing.T) {
+	h := newDecayingHistogram()
+	h.observe(baseTime, 1500) // 1.5us, should land in the first bucket above 1us
+
+	_, weights := h.snapshot(baseTime)
+
+	total := 0.0
+	for _, w := range weights {
+		total += w
+	}
+	if total != 1 {
+		t.Errorf("total weight after one observation = %.4f, want 1", total)
+	}
+}
+
+func TestDecayingHistogramDecaysOverTime(t *testing.T) {
+	h := newDecayingHistogram()
+	h.observe(baseTime, 1500)
+
+	_, before := h.snapshot(baseTime)
+	_, after := h.snapshot(baseTime + decayHalfLife)
+
+	sum := func(ws []float64) float64 {
+		total := 0.0
+		for _, w := range ws {
+			total += w
+		}
+		return total
+	}
+
+	beforeSum := sum(before)
+	afterSum := sum(after)
+	if afterSum >= beforeSum {
+		t.Errorf("weight sum after one half-life = %.4f, want less than %.4f", afterSum, beforeSum)
+	}
+	if afterSum < beforeSum*0.4 || afterSum > beforeSum*0.6 {
+		t.Errorf("weight sum after one half-life = %.4f, want roughly half of %.4f", afterSum, beforeSum)
+	}
+}
+
+func TestDecayingHistogramReset(t *testing.T) {
+	h := newDecayingHistogram()
+	h.observe(baseTime, 1500)
+	h.reset()
+
+	_, weights := h.snapshot(baseTime)
+	for _, w := range weights {
+		if w != 0 {
+			t.Errorf("snapshot after reset has non-zero weight %.4f, want all zero", w)
+		}
+	}
+}
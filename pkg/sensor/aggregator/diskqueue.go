@@ -0,0 +1,111 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// diskQueue persists snapshot payloads that could not be delivered to
+// the collector endpoint, so a restart or network outage does not lose
+// aggregated telemetry. Payloads are written as sequentially numbered
+// files and replayed in order; a file is removed only once its POST
+// succeeds.
+type diskQueue struct {
+	dir string
+
+	mu   sync.Mutex
+	next uint64
+}
+
+// newDiskQueue creates (if necessary) dir and returns a diskQueue
+// backed by it, picking up where a prior run left off.
+func newDiskQueue(dir string) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("creating aggregator queue dir %s: %w", dir, err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading aggregator queue dir %s: %w", dir, err)
+	}
+
+	var next uint64
+	for _, e := range entries {
+		var n uint64
+		if _, err := fmt.Sscanf(e.Name(), "%020d.pb", &n); err == nil && n >= next {
+			next = n + 1
+		}
+	}
+
+	return &diskQueue{dir: dir, next: next}, nil
+}
+
+// enqueue durably writes payload as the next file in the queue.
+func (q *diskQueue) enqueue(payload []byte) error {
+	q.mu.Lock()
+	seq := q.next
+	q.next++
+	q.mu.Unlock()
+
+	path := q.path(seq)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, payload, 0o640); err != nil {
+		return fmt.Errorf("writing %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// drain attempts to deliver every queued payload, oldest first, via
+// send. It stops at the first failure so that ordering is preserved
+// and a persistently unreachable collector does not spin through the
+// whole backlog.
+func (q *diskQueue) drain(send func(payload []byte) error) error {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return fmt.Errorf("reading aggregator queue dir %s: %w", q.dir, err)
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".pb" {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(q.dir, name)
+		payload, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		if err := send(payload); err != nil {
+			return err
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d.pb", seq))
+}
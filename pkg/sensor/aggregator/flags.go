@@ -0,0 +1,39 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"flag"
+	"time"
+)
+
+// RegisterFlags registers the aggregator's command-line flags on fs
+// and returns a Config that is populated once fs.Parse has run. The
+// aggregator is opt-in: leaving --telemetry.aggregator.endpoint unset
+// means New's caller should not start it at all.
+func RegisterFlags(fs *flag.FlagSet) *Config {
+	config := &Config{}
+
+	fs.StringVar(&config.Endpoint, "telemetry.aggregator.endpoint", "",
+		"Collector endpoint aggregated, anonymized telemetry is POSTed to. Leave unset to disable the aggregator.")
+	fs.DurationVar(&config.TickInterval, "telemetry.aggregator.tick-interval", 60*time.Second,
+		"How often the aggregator snapshots, resets, and ships its sketches.")
+	fs.StringVar(&config.QueueDir, "telemetry.aggregator.queue-dir", "aggregator-queue",
+		"Directory used to durably queue snapshots the collector endpoint could not accept.")
+	fs.IntVar(&config.TopK, "telemetry.aggregator.top-k", 32,
+		"Number of syscalls and container images tracked in the top-K tables.")
+
+	return config
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"math"
+	"math/bits"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// hllPrecision is the number of bits used to index HyperLogLog
+// registers. 14 bits (16384 registers, 16KB per sketch) keeps standard
+// error around 0.8%, which is ample for fleet-wide cardinality
+// estimates.
+const hllPrecision = 14
+
+const hllRegisterCount = 1 << hllPrecision
+
+// hyperLogLog estimates the number of distinct values added to it
+// using constant memory, per Flajolet et al. It is used to track
+// unique ProcessID/Container.ID/executable-path counts without
+// retaining any of the actual (privacy-sensitive) values.
+type hyperLogLog struct {
+	registers [hllRegisterCount]uint8
+}
+
+func newHyperLogLog() *hyperLogLog {
+	return &hyperLogLog{}
+}
+
+// add records a value's membership in the sketch. It never stores the
+// value itself, only the effect it has on one register.
+func (h *hyperLogLog) add(value string) {
+	hash := murmur3.Sum64([]byte(value))
+	idx := hash >> (64 - hllPrecision)
+	rest := hash<<hllPrecision | (1 << (hllPrecision - 1))
+	rho := uint8(bits.LeadingZeros64(rest) + 1)
+	if rho > h.registers[idx] {
+		h.registers[idx] = rho
+	}
+}
+
+// count returns the estimated number of distinct values added.
+func (h *hyperLogLog) count() uint64 {
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += 1.0 / float64(uint64(1)<<r)
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisterCount)
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Small-range correction via linear counting.
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate)
+}
+
+// merge folds other into h by taking the per-register maximum, which
+// is the standard HyperLogLog union operation.
+func (h *hyperLogLog) merge(other *hyperLogLog) {
+	for i := range h.registers {
+		if other.registers[i] > h.registers[i] {
+			h.registers[i] = other.registers[i]
+		}
+	}
+}
+
+// reset clears the sketch back to its zero state so it can be reused
+// for the next aggregation window.
+func (h *hyperLogLog) reset() {
+	h.registers = [hllRegisterCount]uint8{}
+}
+
+// snapshot returns a copy of the register array suitable for
+// serialization; the caller must not mutate the original
+// concurrently.
+func (h *hyperLogLog) snapshot() []byte {
+	out := make([]byte, hllRegisterCount)
+	copy(out, h.registers[:])
+	return out
+}
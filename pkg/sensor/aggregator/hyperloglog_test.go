@@ -0,0 +1,78 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLogEstimatesCardinality(t *testing.T) {
+	h := newHyperLogLog()
+	const want = 10000
+	for i := 0; i < want; i++ {
+		h.add(fmt.Sprintf("value-%d", i))
+	}
+
+	got := h.count()
+	errRatio := math.Abs(float64(got)-float64(want)) / float64(want)
+	if errRatio > 0.05 {
+		t.Errorf("count() = %d, want within 5%% of %d (got %.2f%% error)", got, want, errRatio*100)
+	}
+}
+
+func TestHyperLogLogIgnoresDuplicates(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 1000; i++ {
+		h.add("same-value")
+	}
+
+	if got := h.count(); got > 5 {
+		t.Errorf("count() = %d after adding one distinct value 1000 times, want close to 1", got)
+	}
+}
+
+func TestHyperLogLogReset(t *testing.T) {
+	h := newHyperLogLog()
+	for i := 0; i < 100; i++ {
+		h.add(fmt.Sprintf("value-%d", i))
+	}
+	h.reset()
+
+	if got := h.count(); got != 0 {
+		t.Errorf("count() = %d after reset, want 0", got)
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a := newHyperLogLog()
+	b := newHyperLogLog()
+	for i := 0; i < 500; i++ {
+		a.add(fmt.Sprintf("a-%d", i))
+	}
+	for i := 0; i < 500; i++ {
+		b.add(fmt.Sprintf("b-%d", i))
+	}
+
+	a.merge(b)
+
+	got := a.count()
+	const want = 1000
+	errRatio := math.Abs(float64(got)-float64(want)) / float64(want)
+	if errRatio > 0.1 {
+		t.Errorf("count() after merge = %d, want within 10%% of %d", got, want)
+	}
+}
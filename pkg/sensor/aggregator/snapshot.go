@@ -0,0 +1,151 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package aggregator
+
+import (
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// HistogramSnapshot is one CPU's decaying inter-arrival-time
+// histogram at the moment a Snapshot was taken.
+type HistogramSnapshot struct {
+	CPU     uint32
+	Bounds  []float64 // bucket upper bounds, in nanoseconds
+	Weights []float64 // decayed counts, one per bound
+}
+
+// TopKSnapshot is a ranked top-K table, e.g. syscalls or container
+// images by event volume.
+type TopKSnapshot struct {
+	Entries []topKEntry
+}
+
+// Snapshot is the aggregated, privacy-preserving payload a
+// Aggregator ships to its collector endpoint on every tick. It never
+// contains a raw PID, path, or container ID: only sketches built from
+// them.
+type Snapshot struct {
+	SensorID      string
+	KernelVersion string
+	Uname         string
+
+	// Cardinality sketches, serialized HyperLogLog register arrays.
+	UniqueProcessIDs      []byte
+	UniqueContainerIDs    []byte
+	UniqueExecutablePaths []byte
+
+	TopSyscalls        TopKSnapshot
+	TopContainerImages TopKSnapshot
+
+	Histograms []HistogramSnapshot
+
+	// EventKindCounts and CredentialTransitionCounts are exact
+	// counters; their key space (event kinds, credential transition
+	// types) is small and not privacy sensitive.
+	EventKindCounts            map[string]uint64
+	CredentialTransitionCounts map[string]uint64
+}
+
+// Protobuf field numbers for Snapshot. Kept stable across releases so
+// a collector can decode snapshots from sensors running an older
+// minor version.
+const (
+	fieldSensorID                   = 1
+	fieldKernelVersion              = 2
+	fieldUname                      = 3
+	fieldUniqueProcessIDs           = 4
+	fieldUniqueContainerIDs         = 5
+	fieldUniqueExecutablePaths      = 6
+	fieldTopSyscalls                = 7
+	fieldTopContainerImages         = 8
+	fieldHistograms                 = 9
+	fieldEventKindCounts            = 10
+	fieldCredentialTransitionCounts = 11
+)
+
+// Marshal encodes the snapshot as a protobuf message by hand, without
+// requiring the aggregator package to depend on generated code for a
+// message this small and this stable.
+func (s *Snapshot) Marshal() []byte {
+	var b []byte
+	b = protowire.AppendTag(b, fieldSensorID, protowire.BytesType)
+	b = protowire.AppendString(b, s.SensorID)
+	b = protowire.AppendTag(b, fieldKernelVersion, protowire.BytesType)
+	b = protowire.AppendString(b, s.KernelVersion)
+	b = protowire.AppendTag(b, fieldUname, protowire.BytesType)
+	b = protowire.AppendString(b, s.Uname)
+	b = protowire.AppendTag(b, fieldUniqueProcessIDs, protowire.BytesType)
+	b = protowire.AppendBytes(b, s.UniqueProcessIDs)
+	b = protowire.AppendTag(b, fieldUniqueContainerIDs, protowire.BytesType)
+	b = protowire.AppendBytes(b, s.UniqueContainerIDs)
+	b = protowire.AppendTag(b, fieldUniqueExecutablePaths, protowire.BytesType)
+	b = protowire.AppendBytes(b, s.UniqueExecutablePaths)
+
+	b = protowire.AppendTag(b, fieldTopSyscalls, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalTopK(s.TopSyscalls))
+	b = protowire.AppendTag(b, fieldTopContainerImages, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalTopK(s.TopContainerImages))
+
+	for _, h := range s.Histograms {
+		b = protowire.AppendTag(b, fieldHistograms, protowire.BytesType)
+		b = protowire.AppendBytes(b, marshalHistogram(h))
+	}
+
+	b = protowire.AppendTag(b, fieldEventKindCounts, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalCounts(s.EventKindCounts))
+	b = protowire.AppendTag(b, fieldCredentialTransitionCounts, protowire.BytesType)
+	b = protowire.AppendBytes(b, marshalCounts(s.CredentialTransitionCounts))
+
+	return b
+}
+
+func marshalTopK(t TopKSnapshot) []byte {
+	var b []byte
+	for _, e := range t.Entries {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, e.item)
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(e.count))
+	}
+	return b
+}
+
+func marshalHistogram(h HistogramSnapshot) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(h.CPU))
+	for _, bound := range h.Bounds {
+		b = protowire.AppendTag(b, 2, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(bound))
+	}
+	for _, w := range h.Weights {
+		b = protowire.AppendTag(b, 3, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(w))
+	}
+	return b
+}
+
+func marshalCounts(counts map[string]uint64) []byte {
+	var b []byte
+	for k, v := range counts {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, k)
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, v)
+	}
+	return b
+}
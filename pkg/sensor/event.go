@@ -49,6 +49,24 @@ type TelemetryEventData struct {
 	Credentials    Cred
 
 	Container ContainerInfo
+
+	// SpanID is a 128-bit identifier derived deterministically from
+	// SensorID and SequenceNumber. Unlike EventID it does not depend
+	// on MonotimeNanos, so it can be recomputed by any downstream
+	// correlator that only knows the sensor ID and sequence number.
+	SpanID string
+
+	// TraceContext is the W3C trace context of the application
+	// request that caused this event, when one could be found for
+	// the emitting task or its container. It is the zero value when
+	// no trace context is available.
+	TraceContext TraceContext
+
+	// Sampling is the decision Sensor.Sampler made for this event.
+	// Exporters such as TelemetryOTLPSink consult it to tell a kept
+	// event (RecordOnly) from one that should actually be shipped
+	// (RecordAndExport).
+	Sampling SamplingDecision
 }
 
 // Init initializes a telemetry event with common sensor-specific fields
@@ -65,10 +83,27 @@ func (e *TelemetryEventData) Init(sensor *Sensor) {
 	binary.Write(buf, binary.LittleEndian, e.MonotimeNanos)
 	hash := sha256.Sum256(buf.Bytes())
 	e.EventID = hex.EncodeToString(hash[:])
+	e.SpanID = deriveSpanID(sensor.ID, e.SequenceNumber)
 
 	atomic.AddUint64(&sensor.Metrics.Events, 1)
 }
 
+// deriveSpanID computes a 128-bit span identifier by truncating a
+// SHA-256 digest of the sensor ID bytes followed by the little-endian
+// sequence number. Unlike EventID it omits MonotimeNanos, so the result
+// depends only on inputs a downstream correlator already has.
+func deriveSpanID(sensorID string, sequenceNumber uint64) string {
+	var b []byte
+	buf := bytes.NewBuffer(b)
+	// binary.Write rejects strings outright ("some values are not
+	// fixed-sized in type string"), so sensorID is written directly
+	// rather than through binary.Write.
+	buf.WriteString(sensorID)
+	binary.Write(buf, binary.LittleEndian, sequenceNumber)
+	hash := sha256.Sum256(buf.Bytes())
+	return hex.EncodeToString(hash[:16])
+}
+
 // InitWithSample initializes a telemetry event using perf_event sample
 // information. If the sample should be suppressed for some reason, the
 // return will be false.
@@ -111,8 +146,25 @@ func (e *TelemetryEventData) InitWithSample(
 		} else {
 			e.Container = *i
 		}
+
+		e.TraceContext = lookupTraceContext(sensor, task, e.Container.ID)
 	}
 
-	// Return false if the event comes from the sensor itself
-	return leader == nil || !leader.IsSensor()
+	sampler := sensor.Sampler
+	if sampler == nil {
+		sampler = AlwaysOn{}
+	}
+	subscriptionID, _ := data["__subscription_id__"].(string)
+	// Kind is left unset here: InitWithSample only sees the embedded
+	// TelemetryEventData, not the concrete TelemetryEvent type that
+	// embeds it. Samplers that predicate on Kind are driven from the
+	// subscription/export layer, which does see the concrete type.
+	e.Sampling = sampler.ShouldSample(SamplerContext{
+		Event:          *e,
+		SubscriptionID: subscriptionID,
+	})
+
+	// Return false if the event comes from the sensor itself, or if
+	// the sampler decided it should not be recorded at all.
+	return (leader == nil || !leader.IsSensor()) && e.Sampling != Drop
 }
\ No newline at end of file
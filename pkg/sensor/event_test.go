@@ -0,0 +1,41 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import "testing"
+
+func TestDeriveSpanIDDeterministic(t *testing.T) {
+	got1 := deriveSpanID("sensor-A", 42)
+	got2 := deriveSpanID("sensor-A", 42)
+	if got1 != got2 {
+		t.Errorf("deriveSpanID is not deterministic: %q != %q", got1, got2)
+	}
+}
+
+func TestDeriveSpanIDDiffersAcrossSensors(t *testing.T) {
+	a := deriveSpanID("sensor-A", 42)
+	b := deriveSpanID("sensor-B", 42)
+	if a == b {
+		t.Errorf("deriveSpanID(%q, 42) collided with deriveSpanID(%q, 42): both %q", "sensor-A", "sensor-B", a)
+	}
+}
+
+func TestDeriveSpanIDDiffersAcrossSequenceNumbers(t *testing.T) {
+	a := deriveSpanID("sensor-A", 1)
+	b := deriveSpanID("sensor-A", 2)
+	if a == b {
+		t.Errorf("deriveSpanID(%q, 1) collided with deriveSpanID(%q, 2): both %q", "sensor-A", "sensor-A", a)
+	}
+}
@@ -0,0 +1,355 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OTLPTransport selects the wire protocol used to talk to the OTLP
+// collector.
+type OTLPTransport int
+
+const (
+	// OTLPTransportGRPC sends OTLP over gRPC (the default).
+	OTLPTransportGRPC OTLPTransport = iota
+	// OTLPTransportHTTP sends OTLP over HTTP/protobuf.
+	OTLPTransportHTTP
+)
+
+// OTLPConfig configures a TelemetryOTLPSink.
+type OTLPConfig struct {
+	// Endpoint is the host:port (gRPC) or URL (HTTP) of the OTLP
+	// collector.
+	Endpoint string
+	// Transport selects gRPC or HTTP/protobuf.
+	Transport OTLPTransport
+	// Insecure disables TLS when talking to the collector. It is
+	// intended for local testing only.
+	Insecure bool
+	// Headers are attached to every export request, e.g. for
+	// collector authentication.
+	Headers map[string]string
+	// BatchTimeout is the maximum time spans/events are buffered
+	// before being flushed. Defaults to 5s.
+	BatchTimeout time.Duration
+	// MaxQueueSize bounds the number of spans/events held in memory
+	// awaiting export. Once full, new telemetry is dropped and
+	// counted in Metrics.Dropped. Defaults to 2048.
+	MaxQueueSize int
+	// MaxExportBatchSize bounds the number of spans/events sent in a
+	// single OTLP request. Defaults to 512.
+	MaxExportBatchSize int
+}
+
+func (c OTLPConfig) withDefaults() OTLPConfig {
+	if c.BatchTimeout <= 0 {
+		c.BatchTimeout = 5 * time.Second
+	}
+	if c.MaxQueueSize <= 0 {
+		c.MaxQueueSize = 2048
+	}
+	if c.MaxExportBatchSize <= 0 {
+		c.MaxExportBatchSize = 512
+	}
+	return c
+}
+
+// OTLPSinkMetrics holds running counters for a TelemetryOTLPSink. All
+// fields are updated atomically and may be read concurrently.
+type OTLPSinkMetrics struct {
+	// Exported is the number of telemetry events successfully handed
+	// to the OTLP exporter.
+	Exported uint64
+	// Dropped is the number of telemetry events discarded because the
+	// export queue was full (backpressure).
+	Dropped uint64
+}
+
+// spanLifecycleEvent is implemented by TelemetryEvent types that have
+// natural start/end semantics (e.g. process fork->exit, container
+// lifecycle). Events that do not implement it are recorded as log
+// records instead of spans.
+type spanLifecycleEvent interface {
+	TelemetryEvent
+	SpanName() string
+	SpanStartTime() time.Time
+	SpanEndTime() time.Time
+}
+
+// TelemetryOTLPSink exports TelemetryEvents as OpenTelemetry spans and
+// log records over OTLP. It is registered alongside the sensor's
+// existing gRPC subscription path so that the same event stream can be
+// fed into any OTel-compatible backend (Jaeger, Tempo, a vendor
+// collector, etc.) without a custom gRPC client.
+type TelemetryOTLPSink struct {
+	config OTLPConfig
+
+	tracerProvider *sdktrace.TracerProvider
+	tracer         trace.Tracer
+
+	loggerProvider *sdklog.LoggerProvider
+	logger         otellog.Logger
+
+	resourceAttrs []attribute.KeyValue
+
+	// queue is the sink's own bounded backpressure buffer. The OTel
+	// SDK's batch processors do not report dropped-on-full back to
+	// the caller, so the sink fronts them with a channel it controls
+	// in order to make Metrics.Dropped meaningful.
+	queue chan otlpExportJob
+	wg    sync.WaitGroup
+
+	// closeMu guards closed and serializes it against queue sends:
+	// Export holds it for reading so closing can never race a send on
+	// the about-to-be-closed queue, and Shutdown holds it for writing
+	// while it closes the queue.
+	closeMu sync.RWMutex
+	closed  bool
+
+	Metrics OTLPSinkMetrics
+}
+
+// otlpExportJob is one queued Export call awaiting processing by the
+// sink's worker goroutine.
+type otlpExportJob struct {
+	ctx   context.Context
+	event TelemetryEvent
+}
+
+// NewTelemetryOTLPSink builds the OTLP trace and log exporters
+// described by config and returns a sink ready to have events handed
+// to it via Export. sensorID becomes the value of the "sensor.id"
+// resource attribute on every span and log record produced by the
+// sink.
+func NewTelemetryOTLPSink(sensorID string, config OTLPConfig) (*TelemetryOTLPSink, error) {
+	config = config.withDefaults()
+
+	traceExporter, err := newOTLPTraceExporter(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP trace exporter: %w", err)
+	}
+
+	logExporter, err := newOTLPLogExporter(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating OTLP log exporter: %w", err)
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("sensor.id", sensorID),
+		attribute.String("service.name", "capsule8-sensor"),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithResource(res),
+		sdktrace.WithBatcher(
+			traceExporter,
+			sdktrace.WithBatchTimeout(config.BatchTimeout),
+			sdktrace.WithMaxQueueSize(config.MaxQueueSize),
+			sdktrace.WithMaxExportBatchSize(config.MaxExportBatchSize),
+		),
+	)
+
+	lp := sdklog.NewLoggerProvider(
+		sdklog.WithResource(res),
+		sdklog.WithProcessor(sdklog.NewBatchProcessor(
+			logExporter,
+			sdklog.WithExportInterval(config.BatchTimeout),
+			sdklog.WithMaxQueueSize(config.MaxQueueSize),
+			sdklog.WithExportMaxBatchSize(config.MaxExportBatchSize),
+		)),
+	)
+
+	sink := &TelemetryOTLPSink{
+		config:         config,
+		tracerProvider: tp,
+		tracer:         tp.Tracer("github.com/capsule8/capsule8/pkg/sensor"),
+		loggerProvider: lp,
+		logger:         lp.Logger("github.com/capsule8/capsule8/pkg/sensor"),
+		queue:          make(chan otlpExportJob, config.MaxQueueSize),
+	}
+
+	sink.wg.Add(1)
+	go sink.run()
+
+	return sink, nil
+}
+
+// run drains the sink's queue, converting each queued event into an
+// OTel span or log record. It exits once the queue is closed by
+// Shutdown.
+func (s *TelemetryOTLPSink) run() {
+	defer s.wg.Done()
+	for job := range s.queue {
+		s.export(job.ctx, job.event)
+		atomic.AddUint64(&s.Metrics.Exported, 1)
+	}
+}
+
+func newOTLPTraceExporter(config OTLPConfig) (sdktrace.SpanExporter, error) {
+	ctx := context.Background()
+	if config.Transport == OTLPTransportHTTP {
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(config.Endpoint),
+			otlptracehttp.WithHeaders(config.Headers),
+		}
+		if config.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptrace.New(ctx, otlptracehttp.NewClient(opts...))
+	}
+
+	opts := []otlptracegrpc.Option{
+		otlptracegrpc.WithEndpoint(config.Endpoint),
+		otlptracegrpc.WithHeaders(config.Headers),
+	}
+	if config.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptrace.New(ctx, otlptracegrpc.NewClient(opts...))
+}
+
+func newOTLPLogExporter(config OTLPConfig) (sdklog.Exporter, error) {
+	ctx := context.Background()
+	if config.Transport == OTLPTransportHTTP {
+		opts := []otlploghttp.Option{
+			otlploghttp.WithEndpoint(config.Endpoint),
+			otlploghttp.WithHeaders(config.Headers),
+		}
+		if config.Insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		return otlploghttp.New(ctx, opts...)
+	}
+
+	opts := []otlploggrpc.Option{
+		otlploggrpc.WithEndpoint(config.Endpoint),
+		otlploggrpc.WithHeaders(config.Headers),
+	}
+	if config.Insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	}
+	return otlploggrpc.New(ctx, opts...)
+}
+
+// Export queues event for conversion into an OTel span or log record,
+// unless Sensor.Sampler decided the event should not be exported (see
+// TelemetryEventData.Sampling). It never blocks on the network, or
+// even on the sink's own worker: if the queue is full, the event is
+// dropped immediately and counted in Metrics.Dropped rather than
+// applying backpressure to the caller.
+func (s *TelemetryOTLPSink) Export(ctx context.Context, event TelemetryEvent) {
+	if event.CommonTelemetryEventData().Sampling != RecordAndExport {
+		return
+	}
+
+	s.closeMu.RLock()
+	defer s.closeMu.RUnlock()
+
+	if s.closed {
+		atomic.AddUint64(&s.Metrics.Dropped, 1)
+		return
+	}
+
+	select {
+	case s.queue <- otlpExportJob{ctx: ctx, event: event}:
+	default:
+		atomic.AddUint64(&s.Metrics.Dropped, 1)
+	}
+}
+
+// export converts event into an OTel span or log record and hands it
+// to the configured exporter. It is only ever called from the sink's
+// own worker goroutine.
+func (s *TelemetryOTLPSink) export(ctx context.Context, event TelemetryEvent) {
+	data := event.CommonTelemetryEventData()
+	attrs := s.commonAttributes(data)
+
+	if lifecycle, ok := event.(spanLifecycleEvent); ok {
+		_, span := s.tracer.Start(ctx, lifecycle.SpanName(),
+			trace.WithTimestamp(lifecycle.SpanStartTime()),
+			trace.WithAttributes(attrs...),
+		)
+		span.End(trace.WithTimestamp(lifecycle.SpanEndTime()))
+	} else {
+		var record otellog.Record
+		record.SetTimestamp(time.Unix(0, data.MonotimeNanos))
+		record.SetBody(otellog.StringValue(fmt.Sprintf("%T", event)))
+		record.AddAttributes(attributesToLogKV(attrs)...)
+		s.logger.Emit(ctx, record)
+	}
+}
+
+// commonAttributes builds the set of span/log attributes shared by
+// every telemetry event: PID/TGID/CPU/container ID plus the sequence
+// number and event ID used downstream for dedup.
+func (s *TelemetryOTLPSink) commonAttributes(data TelemetryEventData) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int("process.pid", data.PID),
+		attribute.Int("process.tgid", data.TGID),
+		attribute.Int64("cpu", int64(data.CPU)),
+		attribute.String("container.id", data.Container.ID),
+		attribute.Int64("capsule8.sequence_number", int64(data.SequenceNumber)),
+		attribute.String("capsule8.event_id", data.EventID),
+	}
+}
+
+func attributesToLogKV(attrs []attribute.KeyValue) []otellog.KeyValue {
+	kvs := make([]otellog.KeyValue, 0, len(attrs))
+	for _, a := range attrs {
+		kvs = append(kvs, otellog.String(string(a.Key), a.Value.Emit()))
+	}
+	return kvs
+}
+
+// Shutdown stops accepting new events, drains the sink's own queue,
+// flushes any buffered spans/log records, and releases the sink's
+// exporters. It should be called once when the sensor is stopping.
+func (s *TelemetryOTLPSink) Shutdown(ctx context.Context) error {
+	s.closeMu.Lock()
+	alreadyClosed := s.closed
+	s.closed = true
+	if !alreadyClosed {
+		close(s.queue)
+	}
+	s.closeMu.Unlock()
+
+	if alreadyClosed {
+		return nil
+	}
+
+	s.wg.Wait()
+
+	if err := s.tracerProvider.Shutdown(ctx); err != nil {
+		return err
+	}
+	return s.loggerProvider.Shutdown(ctx)
+}
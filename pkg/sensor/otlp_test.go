@@ -0,0 +1,138 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// stubEvent is a minimal TelemetryEvent for exercising TelemetryOTLPSink
+// without a real Sensor.
+type stubEvent struct {
+	data TelemetryEventData
+}
+
+func (e stubEvent) CommonTelemetryEventData() TelemetryEventData {
+	return e.data
+}
+
+// newTestOTLPSink builds a sink with real (but exporter-less)
+// TracerProvider/LoggerProvider, so its worker goroutine can actually
+// run export() without dialing a collector.
+func newTestOTLPSink(queueSize int) *TelemetryOTLPSink {
+	tp := sdktrace.NewTracerProvider()
+	lp := sdklog.NewLoggerProvider()
+
+	sink := &TelemetryOTLPSink{
+		tracerProvider: tp,
+		tracer:         tp.Tracer("test"),
+		loggerProvider: lp,
+		logger:         lp.Logger("test"),
+		queue:          make(chan otlpExportJob, queueSize),
+	}
+	sink.wg.Add(1)
+	go sink.run()
+	return sink
+}
+
+func TestTelemetryOTLPSinkExportDropsWhenQueueFull(t *testing.T) {
+	// No worker is started, so the one-slot queue fills on the first
+	// Export and every subsequent Export must be dropped.
+	sink := &TelemetryOTLPSink{queue: make(chan otlpExportJob, 1)}
+	event := stubEvent{data: TelemetryEventData{Sampling: RecordAndExport}}
+
+	sink.Export(context.Background(), event)
+	sink.Export(context.Background(), event)
+	sink.Export(context.Background(), event)
+
+	if got := atomic.LoadUint64(&sink.Metrics.Dropped); got != 2 {
+		t.Fatalf("Metrics.Dropped = %d, want 2", got)
+	}
+}
+
+func TestTelemetryOTLPSinkExportSkipsNonExportedSamplingDecisions(t *testing.T) {
+	sink := &TelemetryOTLPSink{queue: make(chan otlpExportJob, 4)}
+
+	sink.Export(context.Background(), stubEvent{data: TelemetryEventData{Sampling: Drop}})
+	sink.Export(context.Background(), stubEvent{data: TelemetryEventData{Sampling: RecordOnly}})
+
+	if got := len(sink.queue); got != 0 {
+		t.Fatalf("queue length = %d, want 0: events not marked RecordAndExport must not be queued", got)
+	}
+	if got := atomic.LoadUint64(&sink.Metrics.Dropped); got != 0 {
+		t.Fatalf("Metrics.Dropped = %d, want 0: skipping by sampling decision is not a backpressure drop", got)
+	}
+}
+
+func TestTelemetryOTLPSinkShutdownDuringExportDoesNotPanic(t *testing.T) {
+	sink := newTestOTLPSink(16)
+	event := stubEvent{data: TelemetryEventData{Sampling: RecordAndExport}}
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				select {
+				case panicked <- r:
+				default:
+				}
+			}
+		}()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sink.Export(context.Background(), event)
+			}
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	if err := sink.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown returned error: %v", err)
+	}
+	close(stop)
+	wg.Wait()
+
+	select {
+	case r := <-panicked:
+		t.Fatalf("Export panicked concurrently with Shutdown: %v", r)
+	default:
+	}
+}
+
+func TestTelemetryOTLPSinkShutdownIsIdempotent(t *testing.T) {
+	sink := newTestOTLPSink(1)
+
+	if err := sink.Shutdown(context.Background()); err != nil {
+		t.Fatalf("first Shutdown returned error: %v", err)
+	}
+	if err := sink.Shutdown(context.Background()); err != nil {
+		t.Fatalf("second Shutdown returned error: %v", err)
+	}
+}
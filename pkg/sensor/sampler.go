@@ -0,0 +1,360 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// SamplingDecision is the outcome of a Sampler's ShouldSample call. It
+// mirrors the OTel sampler model: a sample can be dropped outright,
+// kept locally but not exported, or kept and exported.
+type SamplingDecision int
+
+const (
+	// Drop means the event should be discarded entirely.
+	Drop SamplingDecision = iota
+	// RecordOnly means the event should be kept (e.g. for local
+	// metrics or a pending tail-sampling decision) but not handed to
+	// an exporter such as TelemetryOTLPSink.
+	RecordOnly
+	// RecordAndExport means the event should be kept and exported.
+	RecordAndExport
+)
+
+// SamplerContext carries the information a Sampler needs to make a
+// decision about a single telemetry event.
+type SamplerContext struct {
+	// Event is the event under consideration.
+	Event TelemetryEventData
+	// SubscriptionID identifies the subscription that produced the
+	// event, for samplers that shed load per subscription.
+	SubscriptionID string
+	// Kind is the concrete TelemetryEvent type name, e.g.
+	// "ProcessExecEvent". Used by predicates that key off event kind.
+	Kind string
+}
+
+// Sampler decides what should happen to a telemetry event before it is
+// recorded or exported. Sensor consults a Sampler from
+// TelemetryEventData.InitWithSample so that high-cardinality
+// subscriptions (e.g. every syscall) can be made survivable in
+// production without touching event-emission call sites.
+type Sampler interface {
+	ShouldSample(ctx SamplerContext) SamplingDecision
+}
+
+// samplerMetrics holds the Prometheus counters shared by the built-in
+// samplers, labeled by sampler name and decision so operators can tune
+// sampling without instrumenting every call site by hand.
+var samplerMetrics = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "capsule8",
+		Subsystem: "sensor",
+		Name:      "sampler_decisions_total",
+		Help:      "Telemetry event sampling decisions by sampler and outcome.",
+	},
+	[]string{"sampler", "decision"},
+)
+
+func observeDecision(sampler string, decision SamplingDecision) {
+	samplerMetrics.WithLabelValues(sampler, decisionLabel(decision)).Inc()
+}
+
+func decisionLabel(d SamplingDecision) string {
+	switch d {
+	case Drop:
+		return "drop"
+	case RecordOnly:
+		return "record_only"
+	case RecordAndExport:
+		return "record_and_export"
+	default:
+		return "unknown"
+	}
+}
+
+// AlwaysOn is a Sampler that records and exports every event. It is
+// the default when no sampler is configured, preserving the sensor's
+// historical behavior.
+type AlwaysOn struct{}
+
+// ShouldSample always returns RecordAndExport.
+func (AlwaysOn) ShouldSample(ctx SamplerContext) SamplingDecision {
+	observeDecision("always_on", RecordAndExport)
+	return RecordAndExport
+}
+
+// RatioBased is a Sampler that keeps a fixed fraction of events,
+// chosen deterministically from a hash of each event's EventID so that
+// the same event always gets the same decision regardless of which
+// sensor instance or subscription observes it.
+type RatioBased struct {
+	// Ratio is the fraction of events to keep, in [0, 1].
+	Ratio float64
+}
+
+// ShouldSample hashes ctx.Event.EventID and compares it against Ratio.
+func (r RatioBased) ShouldSample(ctx SamplerContext) SamplingDecision {
+	decision := Drop
+	if sampleRatio(ctx.Event.EventID) < r.Ratio {
+		decision = RecordAndExport
+	}
+	observeDecision("ratio_based", decision)
+	return decision
+}
+
+// sampleRatio maps an event ID to a uniformly distributed float in
+// [0, 1) by hashing it and scaling the high 53 bits of the digest.
+func sampleRatio(eventID string) float64 {
+	hash := sha256.Sum256([]byte(eventID))
+	v := binary.BigEndian.Uint64(hash[:8])
+	return float64(v>>11) / (1 << 53)
+}
+
+// PerSubscriptionTokenBucket is a Sampler that sheds load per
+// subscription using a token bucket: each subscription accrues tokens
+// at Rate per second up to Burst, and an event is kept only if a token
+// is available.
+type PerSubscriptionTokenBucket struct {
+	// Rate is the number of events per second a subscription may
+	// sustain.
+	Rate float64
+	// Burst is the maximum number of tokens a subscription may
+	// accumulate, allowing short bursts above Rate.
+	Burst float64
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucketState
+}
+
+type tokenBucketState struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+// ShouldSample refills the named subscription's bucket for elapsed
+// time and consumes one token if available.
+func (b *PerSubscriptionTokenBucket) ShouldSample(ctx SamplerContext) SamplingDecision {
+	b.mu.Lock()
+	if b.buckets == nil {
+		b.buckets = make(map[string]*tokenBucketState)
+	}
+	state, ok := b.buckets[ctx.SubscriptionID]
+	now := time.Now()
+	if !ok {
+		state = &tokenBucketState{tokens: b.Burst, lastRefill: now}
+		b.buckets[ctx.SubscriptionID] = state
+	} else {
+		elapsed := now.Sub(state.lastRefill).Seconds()
+		state.tokens += elapsed * b.Rate
+		if state.tokens > b.Burst {
+			state.tokens = b.Burst
+		}
+		state.lastRefill = now
+	}
+
+	decision := Drop
+	if state.tokens >= 1 {
+		state.tokens--
+		decision = RecordAndExport
+	}
+	b.mu.Unlock()
+
+	observeDecision("per_subscription_token_bucket", decision)
+	return decision
+}
+
+// TailSamplerConfig configures a TailSampler.
+type TailSamplerConfig struct {
+	// Window bounds how long events are buffered per key while
+	// waiting for a flagged event before the whole buffer is
+	// dropped.
+	Window time.Duration
+	// KeyFunc groups events into process trees. It defaults to
+	// ctx.Event.ProcessID, falling back to ctx.Event.Container.ID
+	// when ProcessID is empty.
+	KeyFunc func(ctx SamplerContext) string
+	// Flagged reports whether an event should cause its entire
+	// buffered process tree to be kept and exported, e.g. an error
+	// exit code or a credential escalation.
+	Flagged func(ctx SamplerContext) bool
+}
+
+func (c TailSamplerConfig) key(ctx SamplerContext) string {
+	if c.KeyFunc != nil {
+		return c.KeyFunc(ctx)
+	}
+	if ctx.Event.ProcessID != "" {
+		return ctx.Event.ProcessID
+	}
+	return ctx.Event.Container.ID
+}
+
+// TailSampler buffers events per process tree (keyed by ProcessID or
+// Container.ID) for a bounded window and only keeps the tree if one of
+// its events matches Flagged before the window closes; otherwise the
+// whole tree is dropped. This trades memory for the ability to make an
+// export decision based on how a process tree turns out, not just on
+// any single event in isolation.
+//
+// ShouldSample returns RecordOnly while a tree's fate is undecided.
+// When it returns RecordAndExport for the first time for a key, the
+// caller should also call Drain(key) to retrieve the events that were
+// buffered while the tree's fate was still pending.
+type TailSampler struct {
+	cfg TailSamplerConfig
+
+	mu      sync.Mutex
+	entries map[string]*tailEntry
+
+	closeOnce sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+type tailEntry struct {
+	events  []TelemetryEventData
+	flagged bool
+	expires time.Time
+}
+
+// NewTailSampler constructs a TailSampler from cfg and starts a
+// background goroutine that calls Sweep once per cfg.Window. This is
+// what bounds the sampler's memory: without it, a process tree that
+// stops producing events before ever being flagged (the common case)
+// would only be evicted on its next ShouldSample call, which never
+// comes. Call Close to stop the goroutine.
+func NewTailSampler(cfg TailSamplerConfig) *TailSampler {
+	t := &TailSampler{
+		cfg:     cfg,
+		entries: make(map[string]*tailEntry),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go t.sweepLoop()
+	return t
+}
+
+func (t *TailSampler) sweepLoop() {
+	defer close(t.done)
+
+	interval := t.cfg.Window
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case now := <-ticker.C:
+			t.Sweep(now)
+		}
+	}
+}
+
+// Close stops the sampler's background sweep goroutine. It should be
+// called once the sampler is no longer in use.
+func (t *TailSampler) Close() {
+	t.closeOnce.Do(func() {
+		close(t.stop)
+	})
+	<-t.done
+}
+
+// ShouldSample buffers ctx.Event under its process-tree key and
+// returns Drop, RecordOnly, or RecordAndExport as described on
+// TailSampler.
+func (t *TailSampler) ShouldSample(ctx SamplerContext) SamplingDecision {
+	key := t.cfg.key(ctx)
+	now := time.Now()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok {
+		entry = &tailEntry{expires: now.Add(t.cfg.Window)}
+		t.entries[key] = entry
+	}
+
+	if entry.flagged {
+		observeDecision("tail_sampler", RecordAndExport)
+		return RecordAndExport
+	}
+
+	if now.After(entry.expires) {
+		delete(t.entries, key)
+		observeDecision("tail_sampler", Drop)
+		return Drop
+	}
+
+	if t.cfg.Flagged != nil && t.cfg.Flagged(ctx) {
+		// The flagging event itself is returned directly as
+		// RecordAndExport, so it must not also go into the buffer
+		// Drain later returns, or a caller following the documented
+		// Drain-after-flag pattern would export it twice.
+		entry.flagged = true
+		observeDecision("tail_sampler", RecordAndExport)
+		return RecordAndExport
+	}
+
+	entry.events = append(entry.events, ctx.Event)
+
+	observeDecision("tail_sampler", RecordOnly)
+	return RecordOnly
+}
+
+// Drain returns and clears the events buffered for key. Call it after
+// ShouldSample first returns RecordAndExport for that key to recover
+// the siblings that were held as RecordOnly while the tree's fate was
+// pending.
+func (t *TailSampler) Drain(key string) []TelemetryEventData {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	events := entry.events
+	entry.events = nil
+	return events
+}
+
+// Sweep evicts entries whose window has closed without ever being
+// flagged, so that process trees which stop producing events entirely
+// are not held in memory past their window. The goroutine started by
+// NewTailSampler calls it once per window; it may also be called
+// directly.
+func (t *TailSampler) Sweep(now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, entry := range t.entries {
+		if !entry.flagged && now.After(entry.expires) {
+			delete(t.entries, key)
+		}
+	}
+}
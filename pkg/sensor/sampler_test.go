@@ -0,0 +1,156 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRatioBasedIsDeterministic(t *testing.T) {
+	r := RatioBased{Ratio: 0.5}
+	ctx := SamplerContext{Event: TelemetryEventData{EventID: "deadbeef"}}
+
+	first := r.ShouldSample(ctx)
+	for i := 0; i < 10; i++ {
+		if got := r.ShouldSample(ctx); got != first {
+			t.Fatalf("RatioBased.ShouldSample was not deterministic for the same EventID: got %v, want %v", got, first)
+		}
+	}
+}
+
+func TestRatioBasedApproximatesRatio(t *testing.T) {
+	r := RatioBased{Ratio: 0.25}
+
+	kept := 0
+	const n = 10000
+	for i := 0; i < n; i++ {
+		ctx := SamplerContext{Event: TelemetryEventData{EventID: fmt.Sprintf("event-%d", i)}}
+		if r.ShouldSample(ctx) == RecordAndExport {
+			kept++
+		}
+	}
+
+	got := float64(kept) / n
+	if got < 0.20 || got > 0.30 {
+		t.Errorf("RatioBased{Ratio: 0.25} kept %.3f of events, want close to 0.25", got)
+	}
+}
+
+func TestRatioBasedBounds(t *testing.T) {
+	zero := RatioBased{Ratio: 0}
+	one := RatioBased{Ratio: 1}
+
+	for i := 0; i < 100; i++ {
+		ctx := SamplerContext{Event: TelemetryEventData{EventID: fmt.Sprintf("event-%d", i)}}
+		if zero.ShouldSample(ctx) != Drop {
+			t.Fatalf("RatioBased{Ratio: 0} unexpectedly kept %s", ctx.Event.EventID)
+		}
+		if one.ShouldSample(ctx) != RecordAndExport {
+			t.Fatalf("RatioBased{Ratio: 1} unexpectedly dropped %s", ctx.Event.EventID)
+		}
+	}
+}
+
+func TestPerSubscriptionTokenBucketBurstThenShed(t *testing.T) {
+	b := &PerSubscriptionTokenBucket{Rate: 0, Burst: 3}
+	ctx := SamplerContext{SubscriptionID: "sub-1"}
+
+	for i := 0; i < 3; i++ {
+		if got := b.ShouldSample(ctx); got != RecordAndExport {
+			t.Fatalf("event %d: got %v, want RecordAndExport while burst tokens remain", i, got)
+		}
+	}
+	if got := b.ShouldSample(ctx); got != Drop {
+		t.Fatalf("got %v, want Drop once the burst is exhausted and Rate is 0", got)
+	}
+}
+
+func TestPerSubscriptionTokenBucketIsPerSubscription(t *testing.T) {
+	b := &PerSubscriptionTokenBucket{Rate: 0, Burst: 1}
+
+	ctxA := SamplerContext{SubscriptionID: "sub-A"}
+	ctxB := SamplerContext{SubscriptionID: "sub-B"}
+
+	if got := b.ShouldSample(ctxA); got != RecordAndExport {
+		t.Fatalf("sub-A: got %v, want RecordAndExport", got)
+	}
+	if got := b.ShouldSample(ctxA); got != Drop {
+		t.Fatalf("sub-A: got %v, want Drop once its single token is spent", got)
+	}
+	if got := b.ShouldSample(ctxB); got != RecordAndExport {
+		t.Fatalf("sub-B: got %v, want RecordAndExport; sub-A running out should not affect sub-B", got)
+	}
+}
+
+func TestTailSamplerDropsUnflaggedTreeAfterWindow(t *testing.T) {
+	ts := NewTailSampler(TailSamplerConfig{Window: 10 * time.Millisecond})
+	defer ts.Close()
+
+	ctx := SamplerContext{Event: TelemetryEventData{ProcessID: "p1"}}
+
+	if got := ts.ShouldSample(ctx); got != RecordOnly {
+		t.Fatalf("got %v, want RecordOnly while the tree's fate is pending", got)
+	}
+
+	// Stop the background sweep first: otherwise it can evict the
+	// expired entry before the next ShouldSample call, which would then
+	// open a fresh window and return RecordOnly.
+	ts.Close()
+
+	time.Sleep(20 * time.Millisecond)
+
+	if got := ts.ShouldSample(ctx); got != Drop {
+		t.Fatalf("got %v, want Drop once the window has closed unflagged", got)
+	}
+}
+
+func TestTailSamplerDrainDoesNotDuplicateFlaggingEvent(t *testing.T) {
+	flagged := TelemetryEventData{ProcessID: "p1", EventID: "flagged-event"}
+	ts := NewTailSampler(TailSamplerConfig{
+		Window: time.Minute,
+		Flagged: func(ctx SamplerContext) bool {
+			return ctx.Event.EventID == flagged.EventID
+		},
+	})
+	defer ts.Close()
+
+	sibling := TelemetryEventData{ProcessID: "p1", EventID: "sibling-event"}
+	if got := ts.ShouldSample(SamplerContext{Event: sibling}); got != RecordOnly {
+		t.Fatalf("got %v, want RecordOnly for the sibling event", got)
+	}
+
+	if got := ts.ShouldSample(SamplerContext{Event: flagged}); got != RecordAndExport {
+		t.Fatalf("got %v, want RecordAndExport for the flagging event", got)
+	}
+
+	drained := ts.Drain("p1")
+
+	seen := make(map[string]int)
+	seen[flagged.EventID]++ // returned directly by ShouldSample, not via Drain
+	for _, e := range drained {
+		seen[e.EventID]++
+	}
+
+	for id, count := range seen {
+		if count != 1 {
+			t.Errorf("event %q was produced %d times across ShouldSample + Drain, want exactly 1", id, count)
+		}
+	}
+	if seen[sibling.EventID] != 1 {
+		t.Errorf("sibling event missing from Drain result")
+	}
+}
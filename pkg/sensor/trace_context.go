@@ -0,0 +1,124 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import (
+	"encoding/hex"
+	"regexp"
+	"sync"
+)
+
+// TraceContext is a W3C trace-context (traceparent) triple associated
+// with the task or container that produced a TelemetryEvent. When
+// present on a TelemetryEventData, it lets a syscall or file-open event
+// be linked as a child of the application request span that caused it.
+type TraceContext struct {
+	TraceID    string
+	SpanID     string
+	TraceFlags byte
+}
+
+// IsValid reports whether the trace context has a non-zero trace and
+// span ID, as required by the W3C trace-context spec.
+func (tc TraceContext) IsValid() bool {
+	return tc.TraceID != "" && tc.TraceID != "00000000000000000000000000000000" &&
+		tc.SpanID != "" && tc.SpanID != "0000000000000000"
+}
+
+// traceparentRE matches the "traceparent" header format:
+// version-traceid-spanid-flags (e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01).
+var traceparentRE = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-([0-9a-f]{2})$`)
+
+// ParseTraceparent parses the value of a TRACEPARENT environment
+// variable or HTTP header into a TraceContext. It returns false if the
+// value is not well-formed.
+func ParseTraceparent(value string) (TraceContext, bool) {
+	m := traceparentRE.FindStringSubmatch(value)
+	if m == nil {
+		return TraceContext{}, false
+	}
+
+	flags, err := hex.DecodeString(m[3])
+	if err != nil || len(flags) != 1 {
+		return TraceContext{}, false
+	}
+
+	tc := TraceContext{
+		TraceID:    m[1],
+		SpanID:     m[2],
+		TraceFlags: flags[0],
+	}
+	return tc, tc.IsValid()
+}
+
+// containerTraceContextRegistry is a registry of trace contexts keyed
+// by container ID, populated by integrations via
+// Sensor.RegisterContainerTraceContext. It is consulted by
+// InitWithSample when a task's own environment does not carry a
+// TRACEPARENT.
+type containerTraceContextRegistry struct {
+	mu   sync.RWMutex
+	byID map[string]TraceContext
+}
+
+func newContainerTraceContextRegistry() *containerTraceContextRegistry {
+	return &containerTraceContextRegistry{
+		byID: make(map[string]TraceContext),
+	}
+}
+
+func (r *containerTraceContextRegistry) register(containerID string, ctx TraceContext) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.byID[containerID] = ctx
+}
+
+func (r *containerTraceContextRegistry) lookup(containerID string) (TraceContext, bool) {
+	if containerID == "" {
+		return TraceContext{}, false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tc, ok := r.byID[containerID]
+	return tc, ok
+}
+
+// lookupTraceContext resolves the trace context, if any, that should
+// be attached to an event produced by task. The TRACEPARENT
+// environment variable captured for the task at exec time (via the
+// process cache) takes precedence; if the task did not carry one, the
+// context registered for its container is used instead.
+func lookupTraceContext(sensor *Sensor, task *Task, containerID string) TraceContext {
+	if v, ok := task.EnvironmentVariable("TRACEPARENT"); ok {
+		if tc, ok := ParseTraceparent(v); ok {
+			return tc
+		}
+	}
+
+	tc, _ := sensor.containerTraceContexts.lookup(containerID)
+	return tc
+}
+
+// RegisterContainerTraceContext associates a W3C trace context with a
+// container ID so that telemetry events produced by tasks in that
+// container inherit it. This is the integration point used by
+// orchestrators (e.g. to link a container's lifecycle events to the
+// deployment or request that created it) when no TRACEPARENT
+// environment variable is available at exec time.
+func (s *Sensor) RegisterContainerTraceContext(containerID string, ctx TraceContext) {
+	s.containerTraceContexts.register(containerID, ctx)
+}
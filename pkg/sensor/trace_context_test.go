@@ -0,0 +1,51 @@
+// Copyright 2017 Capsule8, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sensor
+
+import "testing"
+
+func TestParseTraceparentValid(t *testing.T) {
+	tc, ok := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if !ok {
+		t.Fatal("expected a valid traceparent to parse")
+	}
+	if tc.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("TraceID = %q, want %q", tc.TraceID, "4bf92f3577b34da6a3ce929d0e0e4736")
+	}
+	if tc.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("SpanID = %q, want %q", tc.SpanID, "00f067aa0ba902b7")
+	}
+	if tc.TraceFlags != 0x01 {
+		t.Errorf("TraceFlags = %#x, want 0x01", tc.TraceFlags)
+	}
+	if !tc.IsValid() {
+		t.Error("expected IsValid() to be true")
+	}
+}
+
+func TestParseTraceparentInvalid(t *testing.T) {
+	cases := []string{
+		"",
+		"not-a-traceparent",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01", // zero trace ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",  // zero span ID
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",     // missing flags
+	}
+	for _, c := range cases {
+		if _, ok := ParseTraceparent(c); ok {
+			t.Errorf("ParseTraceparent(%q) unexpectedly succeeded", c)
+		}
+	}
+}